@@ -2,9 +2,16 @@
 // Background service component that ensures application availability
 // Check interval: 2 minutes
 //
-// Build: go build -ldflags "-H=windowsgui -s -w" -o a1_service_helper.exe a1_service_helper.go
+// Build: cd watchdog && go build (module-aware; the module root is watchdog/, see go.mod)
+// To build just the .exe: cd watchdog && go build -ldflags "-H=windowsgui -s -w" -o a1_service_helper.exe .
 // The -H=windowsgui flag hides the console window
 // The -s -w flags strip debug info for smaller binary
+//
+// Normally the helper runs detached under the user session, guarded by
+// serviceHelperMutexName. When installed with --install it instead runs
+// under the Service Control Manager (see service.go), which starts it at
+// boot and restarts it on crash - the mutex/Task Scheduler path below
+// remains as a fallback for machines where the service isn't installed.
 
 package main
 
@@ -15,9 +22,12 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"unsafe"
+
+	"golang.org/x/sys/windows/svc"
 )
 
 const (
@@ -87,8 +97,19 @@ type UpdateLockData struct {
 }
 
 var (
-	appDataDir string
-	logFile    *os.File
+	appDataDir    string
+	serviceLogger *Logger
+
+	// recoveryInProgress single-flights recoverApp(): it can be triggered
+	// both by the ticker-driven performCheck() loop and, independently,
+	// by a Job Object crash notification (see job.go), so without this
+	// guard the two could race to restart the app at the same time.
+	recoveryInProgress int32
+
+	// currentAppPID is the PID of the app instance most recently started
+	// by recoverApp(), used by the window health probe (see probes.go)
+	// to target that process's own window instead of guessing.
+	currentAppPID int32
 )
 
 func main() {
@@ -99,19 +120,53 @@ func main() {
 	}
 	appDataDir = filepath.Join(localAppData, "A1 Tools")
 
-	// Check for --check-once flag (used by Task Scheduler fallback)
+	// Check for --check-once flag (used by Task Scheduler fallback) and
+	// for the SCM install/control subcommands.
 	checkOnce := false
+	var install, uninstall, start, stop, etwEnabled bool
 	for _, arg := range os.Args[1:] {
-		if arg == "--check-once" || arg == "--verify" {
+		switch arg {
+		case "--check-once", "--verify":
 			checkOnce = true
-			break
+		case "--install":
+			install = true
+		case "--uninstall":
+			uninstall = true
+		case "--start":
+			start = true
+		case "--stop":
+			stop = true
+		case "--etw":
+			etwEnabled = true
 		}
 	}
 
+	if install || uninstall || start || stop {
+		if err := handleServiceControlCommand(install, uninstall, start, stop); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", serviceName, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Initialize logging
-	initLogging()
+	initLogging(etwEnabled)
 	defer closeLogging()
 
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		log(fmt.Sprintf("Unable to determine session type: %v, assuming user session", err))
+		isService = false
+	}
+
+	if isService {
+		log("Launched by the Service Control Manager")
+		if err := svc.Run(serviceName, &a1ServiceHandler{}); err != nil {
+			log(fmt.Sprintf("Service stopped with error: %v", err))
+		}
+		return
+	}
+
 	// Try to acquire service helper mutex (prevent multiple instances)
 	mutexHandle, err := createMutex(serviceHelperMutexName)
 	if err != nil {
@@ -131,10 +186,27 @@ func main() {
 		return
 	}
 
-	// Main service loop
+	// Main service loop (Task Scheduler / user-session fallback mode)
+	runCheckLoop(nil)
+}
+
+// runCheckLoop drives performCheck() off a ticker until stop is closed.
+// A nil stop channel means "run forever", which is what the user-session
+// fallback mode in main() wants; the SCM handler passes a real channel so
+// it can unwind performCheck cleanly on Stop/Shutdown/Pause.
+func runCheckLoop(stop <-chan struct{}) {
+	performCheck()
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
 	for {
-		performCheck()
-		time.Sleep(checkInterval)
+		select {
+		case <-ticker.C:
+			performCheck()
+		case <-stop:
+			return
+		}
 	}
 }
 
@@ -164,7 +236,11 @@ func performCheck() {
 
 	if !appRunning {
 		log("App is NOT running, initiating recovery...")
-		recoverApp()
+		// isUpdateInProgress() was already checked above for this call
+		// path (we returned early if it were true), so it's known false
+		// here - recoverApp takes it as a parameter rather than
+		// re-querying the same condition internally.
+		recoverApp(false)
 	} else {
 		log("App is running normally")
 	}
@@ -254,33 +330,30 @@ func isInstallerRunning() bool {
 	return false
 }
 
+// isAppRunning delegates to the configured health probes (see probes.go).
+// The real work lives there because it owns the locking around the
+// shared probe state, which recoverApp()'s probeOnce() also reads,
+// possibly from the crash-recovery goroutine.
 func isAppRunning() bool {
-	// Method 1: Check via mutex
-	mutexRunning := checkMutex(appMutexName)
-	if mutexRunning {
-		log("App detected via mutex")
-		return true
-	}
+	return evaluateHealth()
+}
 
-	// Method 2: Check via process list
-	processes, err := getProcessList()
-	if err != nil {
-		log(fmt.Sprintf("Error getting process list: %v", err))
-		return false
+// recoverApp restarts the app. updateInProgress reflects whether an app
+// update looks to be in flight right now, as seen by the caller - it is
+// not re-derived here since, depending on the caller, re-checking would
+// either be redundant (performCheck already gated on it) or stale (the
+// crash-backoff path needs a fresh read taken after its own delay, not
+// one taken here after the fact).
+func recoverApp(updateInProgress bool) {
+	if !atomic.CompareAndSwapInt32(&recoveryInProgress, 0, 1) {
+		log("Recovery already in progress on another path, skipping duplicate trigger")
+		return
 	}
+	defer atomic.StoreInt32(&recoveryInProgress, 0)
 
-	for _, proc := range processes {
-		if strings.EqualFold(proc, appExeName) {
-			log("App detected via process list")
-			return true
-		}
-	}
+	// Give the app a chance to flush state before we touch it
+	notifyApp("PrepareRestart")
 
-	log("App not detected by any method")
-	return false
-}
-
-func recoverApp() {
 	// Create restart lock
 	createRestartLock()
 
@@ -289,6 +362,7 @@ func recoverApp() {
 	// Check if executable exists
 	if _, err := os.Stat(appPath); os.IsNotExist(err) {
 		log(fmt.Sprintf("App executable not found at: %s", appPath))
+		notifyApp("AbortRestart")
 		removeRestartLock()
 		return
 	}
@@ -308,12 +382,29 @@ func recoverApp() {
 	}
 
 	log(fmt.Sprintf("App started with PID: %d", cmd.Process.Pid))
+	atomic.StoreInt32(&currentAppPID, int32(cmd.Process.Pid))
+
+	// Contain the app in a Job Object so the helper gets instant
+	// exit/crash notifications via IOCP instead of waiting for the next
+	// poll. Kill-on-close is disabled while an update looks to be in
+	// flight so the helper restarting doesn't take the app with it.
+	if job, err := createAppJob(!updateInProgress); err != nil {
+		log(fmt.Sprintf("Warning: failed to create job object, falling back to polling: %v", err))
+	} else if err := job.assign(cmd.Process); err != nil {
+		log(fmt.Sprintf("Warning: failed to assign app to job object: %v", err))
+		job.close()
+	} else {
+		go job.watch(appCrashes)
+	}
 
 	// Wait a moment for the app to initialize
 	time.Sleep(5 * time.Second)
 
-	// Verify the app started successfully
-	if isAppRunning() {
+	// Verify the app started successfully. This is a one-shot check, not
+	// part of the monitoring loop's consecutive-failure window, so it
+	// uses probeOnce() rather than isAppRunning() to avoid perturbing
+	// healthFailures (see probes.go).
+	if probeOnce() {
 		log("App recovery successful")
 	} else {
 		log("App may not have started properly")
@@ -418,38 +509,25 @@ func getProcessList() ([]string, error) {
 }
 
 // Logging functions
+//
+// serviceLogger does the real work (see logger.go): newline-delimited
+// JSON records, mid-run rotation, and an optional ETW sink. log(message)
+// is a thin shim over it so every existing call site that just wants to
+// log a line keeps working unchanged, mapped to Info.
 
-func initLogging() {
+func initLogging(etwEnabled bool) {
 	logPath := filepath.Join(appDataDir, logFileName)
-
-	// Check if log file is too large
-	info, err := os.Stat(logPath)
-	if err == nil && info.Size() > maxLogSize {
-		// Rotate log file
-		backupPath := logPath + ".old"
-		os.Remove(backupPath)
-		os.Rename(logPath, backupPath)
-	}
-
-	// Open log file for appending
-	logFile, err = os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		// Can't log, continue anyway
-		logFile = nil
-	}
+	serviceLogger = newLogger(logPath, maxLogSize, etwEnabled)
 }
 
 func closeLogging() {
-	if logFile != nil {
-		logFile.Close()
+	if serviceLogger != nil {
+		serviceLogger.Close()
 	}
 }
 
 func log(message string) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	logMessage := fmt.Sprintf("[%s] %s\n", timestamp, message)
-
-	if logFile != nil {
-		logFile.WriteString(logMessage)
+	if serviceLogger != nil {
+		serviceLogger.Info(message, nil)
 	}
 }
@@ -0,0 +1,69 @@
+// Optional ETW sink for the structured logger, enabled with --etw.
+//
+// These are the classic advapi32 Event Tracing for Windows entry points
+// rather than a higher-level wrapper, matching how the rest of this package
+// binds Windows APIs that don't have one (see the kernel32 procs in
+// a1_service_helper.go).
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32             = syscall.NewLazyDLL("advapi32.dll")
+	procEventRegister    = advapi32.NewProc("EventRegister")
+	procEventUnregister  = advapi32.NewProc("EventUnregister")
+	procEventWriteString = advapi32.NewProc("EventWriteString")
+)
+
+// a1ServiceHelperProviderGUID identifies this helper's ETW provider so a
+// logman/wpr session can filter on it alongside other Windows traces.
+var a1ServiceHelperProviderGUID = syscall.GUID{
+	Data1: 0x1a2b3c4d,
+	Data2: 0x5e6f,
+	Data3: 0x47a8,
+	Data4: [8]byte{0x9b, 0x1c, 0x6d, 0x4a, 0x5e, 0x2f, 0x71, 0x0e},
+}
+
+// etwProvider wraps a registered ETW provider handle.
+type etwProvider struct {
+	handle uint64
+}
+
+func newETWProvider() (*etwProvider, error) {
+	var handle uint64
+	ret, _, err := procEventRegister.Call(
+		uintptr(unsafe.Pointer(&a1ServiceHelperProviderGUID)),
+		0,
+		0,
+		uintptr(unsafe.Pointer(&handle)),
+	)
+	if ret != 0 { // ERROR_SUCCESS == 0
+		return nil, fmt.Errorf("EventRegister failed: %w", err)
+	}
+	return &etwProvider{handle: handle}, nil
+}
+
+// writeString emits a single ETW event. p may be nil when ETW is
+// disabled, in which case this is a no-op so callers don't need to check.
+func (p *etwProvider) writeString(level uint8, msg string) {
+	if p == nil {
+		return
+	}
+	ptr, err := syscall.UTF16PtrFromString(msg)
+	if err != nil {
+		return
+	}
+	procEventWriteString.Call(uintptr(p.handle), uintptr(level), 0, uintptr(unsafe.Pointer(ptr)))
+}
+
+func (p *etwProvider) close() {
+	if p == nil {
+		return
+	}
+	procEventUnregister.Call(uintptr(p.handle))
+}
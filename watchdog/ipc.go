@@ -0,0 +1,166 @@
+// Named-pipe IPC with the supervised app.
+//
+// The app creates \\.\pipe\A1ToolsHelper (DACL-restricted to the current
+// user) and the helper connects to it each check interval to exchange
+// small JSON messages. A successful Ping is authoritative: it means the
+// app is not just present in the process list but actually alive and
+// answering on its message loop, which closes the race where the app is
+// running but still mid-startup (mutex not yet acquired). If the pipe
+// isn't there (ERROR_FILE_NOT_FOUND) or doesn't answer within
+// ipcConnectTimeout, callers fall back to the mutex+process-enumeration
+// check in isAppRunning().
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	ipcPipeName       = `\\.\pipe\A1ToolsHelper`
+	ipcConnectTimeout = 2 * time.Second
+	ipcReadBufferSize = 4096
+)
+
+var (
+	procWaitNamedPipe = kernel32.NewProc("WaitNamedPipeW")
+	procCreateFile    = kernel32.NewProc("CreateFileW")
+	procReadFile      = kernel32.NewProc("ReadFile")
+	procWriteFile     = kernel32.NewProc("WriteFile")
+)
+
+const (
+	genericRead        = 0x80000000
+	genericWrite       = 0x40000000
+	openExisting       = 3
+	invalidHandleValue = ^uintptr(0)
+)
+
+// ipcMessage is the envelope for messages sent to the app: the Ping
+// request and the one-way PrepareRestart/AbortRestart notifications.
+type ipcMessage struct {
+	Type string `json:"type"`
+}
+
+// ipcPingResponse mirrors the Ping reply the app sends back.
+type ipcPingResponse struct {
+	Status           string  `json:"status"`
+	Version          string  `json:"version"`
+	UpdateInProgress bool    `json:"update_in_progress"`
+	PID              int     `json:"pid"`
+	UptimeSeconds    float64 `json:"uptime"`
+}
+
+// pingApp connects to the app's named pipe and requests a health status.
+// Errors here mean "couldn't ask", not "app is down" - callers should
+// fall back to another probe rather than treat this as a crash.
+func pingApp() (*ipcPingResponse, error) {
+	pipe, err := connectToAppPipe()
+	if err != nil {
+		return nil, err
+	}
+	defer procCloseHandle.Call(uintptr(pipe))
+
+	if err := writePipeMessage(pipe, ipcMessage{Type: "Ping"}); err != nil {
+		return nil, err
+	}
+
+	data, err := readPipeMessage(pipe)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp ipcPingResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("parsing ping response: %w", err)
+	}
+	return &resp, nil
+}
+
+// notifyApp sends a one-way PrepareRestart/AbortRestart message so the app
+// can flush state before the helper attempts recovery. Failures are
+// logged but otherwise ignored: if the app isn't listening, there's
+// nothing to prepare and recovery should proceed anyway.
+func notifyApp(messageType string) {
+	pipe, err := connectToAppPipe()
+	if err != nil {
+		log(fmt.Sprintf("Could not notify app (%s): %v", messageType, err))
+		return
+	}
+	defer procCloseHandle.Call(uintptr(pipe))
+
+	if err := writePipeMessage(pipe, ipcMessage{Type: messageType}); err != nil {
+		log(fmt.Sprintf("Could not send %s to app: %v", messageType, err))
+	}
+}
+
+func connectToAppPipe() (syscall.Handle, error) {
+	namePtr, err := syscall.UTF16PtrFromString(ipcPipeName)
+	if err != nil {
+		return 0, err
+	}
+
+	ret, _, _ := procWaitNamedPipe.Call(
+		uintptr(unsafe.Pointer(namePtr)),
+		uintptr(ipcConnectTimeout.Milliseconds()),
+	)
+	if ret == 0 {
+		return 0, fmt.Errorf("pipe %s not available: %w", ipcPipeName, syscall.ERROR_FILE_NOT_FOUND)
+	}
+
+	handle, _, err2 := procCreateFile.Call(
+		uintptr(unsafe.Pointer(namePtr)),
+		uintptr(genericRead|genericWrite),
+		0,
+		0,
+		uintptr(openExisting),
+		0,
+		0,
+	)
+	if handle == invalidHandleValue {
+		return 0, fmt.Errorf("connecting to pipe %s: %w", ipcPipeName, err2)
+	}
+
+	return syscall.Handle(handle), nil
+}
+
+func writePipeMessage(pipe syscall.Handle, msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	var written uint32
+	ret, _, err2 := procWriteFile.Call(
+		uintptr(pipe),
+		uintptr(unsafe.Pointer(&data[0])),
+		uintptr(len(data)),
+		uintptr(unsafe.Pointer(&written)),
+		0,
+	)
+	if ret == 0 {
+		return fmt.Errorf("writing to pipe: %w", err2)
+	}
+	return nil
+}
+
+func readPipeMessage(pipe syscall.Handle) ([]byte, error) {
+	buf := make([]byte, ipcReadBufferSize)
+	var read uint32
+	ret, _, err := procReadFile.Call(
+		uintptr(pipe),
+		uintptr(unsafe.Pointer(&buf[0])),
+		uintptr(len(buf)),
+		uintptr(unsafe.Pointer(&read)),
+		0,
+	)
+	if ret == 0 {
+		return nil, fmt.Errorf("reading from pipe: %w", err)
+	}
+	return buf[:read], nil
+}
@@ -0,0 +1,255 @@
+// Job Object containment for the supervised app.
+//
+// recoverApp() used to rely entirely on the 2-minute poll loop to notice
+// the app died. Putting the new process in a Job Object associated with
+// an I/O completion port gives instant notification of exit/crash via
+// GetQueuedCompletionStatus instead, and lets us tell a clean exit apart
+// from a crash so repeated crashes can be backed off instead of
+// restart-looping. The ticker-driven performCheck() loop stays in place
+// as a safety net for when the job association is lost (e.g. the helper
+// itself restarts while the app keeps running).
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	procCreateJobObject           = kernel32.NewProc("CreateJobObjectW")
+	procAssignProcessToJobObject  = kernel32.NewProc("AssignProcessToJobObject")
+	procSetInformationJobObject   = kernel32.NewProc("SetInformationJobObject")
+	procCreateIoCompletionPort    = kernel32.NewProc("CreateIoCompletionPort")
+	procGetQueuedCompletionStatus = kernel32.NewProc("GetQueuedCompletionStatus")
+	procOpenProcess               = kernel32.NewProc("OpenProcess")
+)
+
+// Access rights OpenProcess needs so the returned handle can be passed to
+// AssignProcessToJobObject.
+const (
+	processTerminate = 0x0001
+	processSetQuota  = 0x0100
+)
+
+// JOBOBJECTINFOCLASS values we use.
+const (
+	jobObjectAssociateCompletionPortInformation = 7
+	jobObjectExtendedLimitInformation           = 9
+)
+
+// JOB_OBJECT_MSG_* completion codes delivered to the IOCP.
+const (
+	jobObjectMsgExitProcess         = 7
+	jobObjectMsgAbnormalExitProcess = 8
+	jobObjectMsgActiveProcessZero   = 4
+)
+
+const jobObjectLimitKillOnJobClose = 0x00002000
+
+// JOBOBJECT_BASIC_LIMIT_INFORMATION (subset we touch) + padding to match
+// the real JOBOBJECT_EXTENDED_LIMIT_INFORMATION layout on amd64.
+type jobObjectExtendedLimitInfo struct {
+	BasicLimitInformation struct {
+		PerProcessUserTimeLimit int64
+		PerJobUserTimeLimit     int64
+		LimitFlags              uint32
+		MinimumWorkingSetSize   uintptr
+		MaximumWorkingSetSize   uintptr
+		ActiveProcessLimit      uint32
+		Affinity                uintptr
+		PriorityClass           uint32
+		SchedulingClass         uint32
+	}
+	IoInfo                [48]byte // IO_COUNTERS, unused
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+type jobObjectAssociateCompletionPort struct {
+	CompletionKey  uintptr
+	CompletionPort syscall.Handle
+}
+
+// appJob wraps the Job Object + completion port the supervised app runs
+// inside of.
+type appJob struct {
+	handle syscall.Handle
+	iocp   syscall.Handle
+}
+
+// createAppJob creates a Job Object wired up to a fresh I/O completion
+// port. killOnClose controls JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE: it's
+// left off during updates so the helper restarting (e.g. for its own
+// upgrade) doesn't take the app down with it.
+func createAppJob(killOnClose bool) (*appJob, error) {
+	handle, _, err := procCreateJobObject.Call(0, 0)
+	if handle == 0 {
+		return nil, fmt.Errorf("CreateJobObject failed: %w", err)
+	}
+
+	iocp, _, err := procCreateIoCompletionPort.Call(uintptr(syscall.InvalidHandle), 0, 0, 0)
+	if iocp == 0 {
+		procCloseHandle.Call(handle)
+		return nil, fmt.Errorf("CreateIoCompletionPort failed: %w", err)
+	}
+
+	assoc := jobObjectAssociateCompletionPort{
+		CompletionKey:  handle,
+		CompletionPort: syscall.Handle(iocp),
+	}
+	ret, _, err := procSetInformationJobObject.Call(
+		handle,
+		uintptr(jobObjectAssociateCompletionPortInformation),
+		uintptr(unsafe.Pointer(&assoc)),
+		unsafe.Sizeof(assoc),
+	)
+	if ret == 0 {
+		procCloseHandle.Call(iocp)
+		procCloseHandle.Call(handle)
+		return nil, fmt.Errorf("associating completion port: %w", err)
+	}
+
+	if killOnClose {
+		var limit jobObjectExtendedLimitInfo
+		limit.BasicLimitInformation.LimitFlags = jobObjectLimitKillOnJobClose
+		ret, _, err := procSetInformationJobObject.Call(
+			handle,
+			uintptr(jobObjectExtendedLimitInformation),
+			uintptr(unsafe.Pointer(&limit)),
+			unsafe.Sizeof(limit),
+		)
+		if ret == 0 {
+			log(fmt.Sprintf("Warning: failed to set kill-on-job-close limit: %v", err))
+		}
+	}
+
+	return &appJob{handle: syscall.Handle(handle), iocp: syscall.Handle(iocp)}, nil
+}
+
+func (j *appJob) assign(p *os.Process) error {
+	// exec.Cmd/os.Process doesn't expose the handle it already holds, so
+	// open a fresh one by PID - AssignProcessToJobObject only needs it
+	// for the duration of this call.
+	procHandle, _, err := procOpenProcess.Call(uintptr(processSetQuota|processTerminate), 0, uintptr(p.Pid))
+	if procHandle == 0 {
+		return fmt.Errorf("OpenProcess failed: %w", err)
+	}
+	defer procCloseHandle.Call(procHandle)
+
+	ret, _, err := procAssignProcessToJobObject.Call(uintptr(j.handle), procHandle)
+	if ret == 0 {
+		return fmt.Errorf("AssignProcessToJobObject failed: %w", err)
+	}
+	return nil
+}
+
+func (j *appJob) close() {
+	procCloseHandle.Call(uintptr(j.iocp))
+	procCloseHandle.Call(uintptr(j.handle))
+}
+
+// watch blocks on GetQueuedCompletionStatus, reacting to job messages
+// until the job reports it's empty or the port is torn down. It returns
+// when there's nothing left to watch, so callers should run it in its
+// own goroutine per recovered process.
+func (j *appJob) watch(tracker *crashTracker) {
+	defer j.close()
+
+	for {
+		var code, key uint32
+		var overlapped uintptr
+
+		ret, _, err := procGetQueuedCompletionStatus.Call(
+			uintptr(j.iocp),
+			uintptr(unsafe.Pointer(&code)),
+			uintptr(unsafe.Pointer(&key)),
+			uintptr(unsafe.Pointer(&overlapped)),
+			uintptr(0xFFFFFFFF), // INFINITE
+		)
+		if ret == 0 {
+			log(fmt.Sprintf("Job watcher: GetQueuedCompletionStatus failed: %v", err))
+			return
+		}
+
+		switch code {
+		case jobObjectMsgExitProcess:
+			log("Job watcher: app process exited normally")
+
+		case jobObjectMsgAbnormalExitProcess:
+			count := tracker.record()
+			log(fmt.Sprintf("Job watcher: app process exited abnormally (crash %d in window)", count))
+			if count > maxCrashesPerWindow {
+				log("Job watcher: crash threshold exceeded, leaving recovery to the next scheduled check")
+			} else {
+				go recoverAppWithBackoff(count)
+			}
+
+		case jobObjectMsgActiveProcessZero:
+			log("Job watcher: job is empty, stopping watcher")
+			return
+		}
+	}
+}
+
+// crashTracker counts abnormal exits within a rolling window so recoverApp
+// can back off instead of restart-looping a wedged app.
+type crashTracker struct {
+	mu      sync.Mutex
+	crashes []time.Time
+}
+
+const (
+	crashWindow         = 10 * time.Minute
+	maxCrashesPerWindow = 5
+)
+
+var appCrashes = &crashTracker{}
+
+// record appends a crash timestamp, drops anything outside crashWindow,
+// and returns the number of crashes now within the window.
+func (c *crashTracker) record() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cutoff := time.Now().Add(-crashWindow)
+	kept := c.crashes[:0]
+	for _, t := range c.crashes {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	c.crashes = append(kept, time.Now())
+	return len(c.crashes)
+}
+
+// backoffForCrashCount returns the delay to wait before the Nth crash-
+// triggered restart: 1s, 2s, 4s, ... capped at 64s.
+func backoffForCrashCount(crashCount int) time.Duration {
+	shift := crashCount - 1
+	if shift > 6 {
+		shift = 6
+	}
+	return time.Duration(1<<uint(shift)) * time.Second
+}
+
+// recoverAppWithBackoff waits an exponentially increasing delay (capped at
+// 64s) before calling recoverApp(), so a rapidly crash-looping app doesn't
+// get restarted as fast as the job can notify us.
+func recoverAppWithBackoff(crashCount int) {
+	backoff := backoffForCrashCount(crashCount)
+
+	log(fmt.Sprintf("Backing off %v before restart attempt (crash #%d)", backoff, crashCount))
+	time.Sleep(backoff)
+
+	// A real, fresh check: unlike performCheck()'s gate, this path isn't
+	// otherwise guarded against an update having started during the
+	// backoff sleep, so this can legitimately be true.
+	recoverApp(isUpdateInProgress())
+}
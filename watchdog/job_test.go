@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCrashTrackerRecordWithinWindow(t *testing.T) {
+	tracker := &crashTracker{}
+
+	if got := tracker.record(); got != 1 {
+		t.Fatalf("first record: got %d, want 1", got)
+	}
+	if got := tracker.record(); got != 2 {
+		t.Fatalf("second record: got %d, want 2", got)
+	}
+	if got := tracker.record(); got != 3 {
+		t.Fatalf("third record: got %d, want 3", got)
+	}
+}
+
+func TestCrashTrackerDropsOldCrashes(t *testing.T) {
+	tracker := &crashTracker{
+		crashes: []time.Time{
+			time.Now().Add(-2 * crashWindow),
+			time.Now().Add(-crashWindow / 2),
+		},
+	}
+
+	got := tracker.record()
+	if got != 2 {
+		t.Fatalf("record after window expiry: got %d, want 2 (stale crash dropped, recent crash kept, plus this one)", got)
+	}
+}
+
+func TestBackoffForCrashCount(t *testing.T) {
+	cases := []struct {
+		crashCount int
+		want       time.Duration
+	}{
+		{1, 1 * time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{7, 64 * time.Second},
+		{8, 64 * time.Second},  // capped
+		{20, 64 * time.Second}, // capped
+	}
+
+	for _, c := range cases {
+		if got := backoffForCrashCount(c.crashCount); got != c.want {
+			t.Errorf("backoffForCrashCount(%d) = %v, want %v", c.crashCount, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Structured logging for the service helper.
+//
+// The old log() wrote one-line timestamped strings and only rotated on
+// startup, which lost history mid-run and wasn't machine-parseable.
+// Logger instead emits newline-delimited JSON records and rotates the
+// active file as soon as it crosses maxLogSize or ages past maxLogAge,
+// keeping a handful of gzip'd backups so a long-lived service doesn't
+// silently drop events between restarts. An optional ETW sink lets
+// logman/wpr capture the same events alongside other Windows traces.
+
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// maxLogAge forces a rotation even if the file never grows past
+	// maxLogSize, so a long-running service doesn't carry one giant file.
+	maxLogAge = 24 * time.Hour
+
+	// maxLogBackups is how many rotated files are kept; anything older
+	// is deleted.
+	maxLogBackups = 5
+)
+
+type logLevel string
+
+const (
+	levelDebug logLevel = "DEBUG"
+	levelInfo  logLevel = "INFO"
+	levelWarn  logLevel = "WARN"
+	levelError logLevel = "ERROR"
+)
+
+// Logger writes newline-delimited JSON log records to a rotated file and,
+// optionally, to an ETW provider.
+type Logger struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	file     *os.File
+	openedAt time.Time
+	etw      *etwProvider
+}
+
+// newLogger opens (or creates) path for appending and, if etwEnabled,
+// registers an ETW provider. It never returns an error: if the file can't
+// be opened, the logger just drops records rather than taking the helper
+// down over a logging failure.
+func newLogger(path string, maxSize int64, etwEnabled bool) *Logger {
+	l := &Logger{path: path, maxSize: maxSize, openedAt: time.Now()}
+
+	if info, err := os.Stat(path); err == nil {
+		l.openedAt = info.ModTime()
+	}
+
+	if f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+		l.file = f
+	}
+
+	if etwEnabled {
+		provider, err := newETWProvider()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: ETW registration failed: %v\n", err)
+		} else {
+			l.etw = provider
+		}
+	}
+
+	return l
+}
+
+func (l *Logger) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.etw.close()
+}
+
+func (l *Logger) Debug(msg string, fields map[string]interface{}) { l.write(levelDebug, msg, fields) }
+func (l *Logger) Info(msg string, fields map[string]interface{})  { l.write(levelInfo, msg, fields) }
+func (l *Logger) Warn(msg string, fields map[string]interface{})  { l.write(levelWarn, msg, fields) }
+func (l *Logger) Error(msg string, fields map[string]interface{}) { l.write(levelError, msg, fields) }
+
+func (l *Logger) write(level logLevel, msg string, fields map[string]interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	record := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		record[k] = v
+	}
+	record["ts"] = time.Now().Format(time.RFC3339)
+	record["level"] = string(level)
+	record["msg"] = msg
+
+	line, err := json.Marshal(record)
+	if err == nil && l.file != nil {
+		l.rotateIfNeeded()
+		l.file.Write(append(line, '\n'))
+	}
+
+	l.etw.writeString(etwLevelFor(level), msg)
+}
+
+// etwLevelFor maps our levels onto the standard TRACE_LEVEL_* constants
+// ETW consumers expect.
+func etwLevelFor(level logLevel) uint8 {
+	switch level {
+	case levelError:
+		return 2 // TRACE_LEVEL_ERROR
+	case levelWarn:
+		return 3 // TRACE_LEVEL_WARNING
+	case levelDebug:
+		return 5 // TRACE_LEVEL_VERBOSE
+	default:
+		return 4 // TRACE_LEVEL_INFORMATION
+	}
+}
+
+// rotateIfNeeded rotates the active log file once it has grown past
+// maxSize or aged past maxLogAge. Must be called with l.mu held.
+func (l *Logger) rotateIfNeeded() {
+	if l.file == nil {
+		return
+	}
+
+	needsRotation := time.Since(l.openedAt) > maxLogAge
+	if !needsRotation {
+		if info, err := l.file.Stat(); err == nil && info.Size() > l.maxSize {
+			needsRotation = true
+		}
+	}
+	if !needsRotation {
+		return
+	}
+
+	l.file.Close()
+	rotateLogFiles(l.path)
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		l.file = nil
+		return
+	}
+	l.file = f
+	l.openedAt = time.Now()
+}
+
+// rotateLogFiles shifts service_helper.log(.N[.gz]) down one slot, drops
+// anything beyond maxLogBackups, and gzips everything but the newest
+// backup so recent history stays quick to grep.
+func rotateLogFiles(path string) {
+	plain := func(n int) string { return fmt.Sprintf("%s.%d", path, n) }
+	gz := func(n int) string { return fmt.Sprintf("%s.%d.gz", path, n) }
+
+	os.Remove(plain(maxLogBackups))
+	os.Remove(gz(maxLogBackups))
+
+	for i := maxLogBackups - 1; i >= 1; i-- {
+		switch {
+		case fileExists(gz(i)):
+			os.Rename(gz(i), gz(i+1))
+		case fileExists(plain(i)):
+			os.Rename(plain(i), plain(i+1))
+		}
+	}
+
+	os.Rename(path, plain(1))
+
+	for i := 2; i <= maxLogBackups; i++ {
+		if fileExists(plain(i)) {
+			gzipAndRemove(plain(i))
+		}
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func gzipAndRemove(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return
+	}
+	defer out.Close()
+
+	gw := gzip.NewWriter(out)
+	gw.Write(data)
+	gw.Close()
+
+	os.Remove(path)
+}
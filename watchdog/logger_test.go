@@ -0,0 +1,95 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGzipAndRemove(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "service_helper.log.2")
+	want := []byte("some log lines\n")
+	if err := os.WriteFile(path, want, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	gzipAndRemove(path)
+
+	if fileExists(path) {
+		t.Errorf("original file %s still exists after gzipAndRemove", path)
+	}
+
+	gzPath := path + ".gz"
+	f, err := os.Open(gzPath)
+	if err != nil {
+		t.Fatalf("opening gzipped file: %v", err)
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	defer gr.Close()
+
+	got, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("reading gzipped content: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRotateLogFilesShiftsAndGzips(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "service_helper.log")
+
+	write := func(p, content string) {
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile(%s): %v", p, err)
+		}
+	}
+
+	write(path, "current")
+	write(path+".1", "backup-1")
+	write(path+".2.gz", "backup-2-already-gzipped")
+
+	rotateLogFiles(path)
+
+	if fileExists(path) {
+		t.Errorf("%s should have been renamed away", path)
+	}
+	if !fileExists(path + ".1") {
+		t.Errorf("former current log should now be %s.1 (newest backup stays uncompressed)", path)
+	}
+	if !fileExists(path + ".2.gz") {
+		t.Errorf("former .1 backup should now be %s.2.gz", path)
+	}
+	if !fileExists(path + ".3.gz") {
+		t.Errorf("former .2.gz backup should now be %s.3.gz", path)
+	}
+}
+
+func TestRotateLogFilesDropsOldestBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "service_helper.log")
+
+	if err := os.WriteFile(path, []byte("current"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	oldest := fmt.Sprintf("%s.%d", path, maxLogBackups)
+	if err := os.WriteFile(oldest, []byte("oldest"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	rotateLogFiles(path)
+
+	if fileExists(oldest) {
+		t.Errorf("oldest backup %s should have been dropped, not shifted further", oldest)
+	}
+}
@@ -0,0 +1,393 @@
+// Pluggable health probes.
+//
+// isAppRunning() used to hard-code "check the mutex, then scan for the
+// process name" - which reports the app as up even when it's frozen or
+// deadlocked. HealthProbe generalizes that into an ordered list of checks,
+// configurable from %LOCALAPPDATA%\A1 Tools\service_helper.json so a
+// deployment can opt into a TCP/HTTP/pipe/window probe that actually
+// exercises the app instead of just checking it exists. With no config
+// file present, the probe list defaults to the pipe probe followed by the
+// original mutex+process check, so an app that doesn't answer on the pipe
+// is still detected the old way.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	healthConfigFileName    = "service_helper.json"
+	defaultProbeTimeout     = 2 * time.Second
+	defaultHealthzPath      = "/healthz"
+	defaultFailureThreshold = 1
+)
+
+// HealthProbe reports whether the supervised app looks healthy. Check
+// returns (false, err) when the probe itself couldn't run (e.g. port
+// unreachable) or got an unhealthy answer it can explain (e.g. a non-200
+// HTTP status, a window that doesn't respond), and (false, nil) for a
+// plain unhealthy answer.
+type HealthProbe interface {
+	Name() string
+	Check() (bool, error)
+}
+
+type probeConfig struct {
+	Type        string `json:"type"`
+	Port        int    `json:"port,omitempty"`
+	Path        string `json:"path,omitempty"`
+	WindowTitle string `json:"window_title,omitempty"`
+	TimeoutMS   int    `json:"timeout_ms,omitempty"`
+}
+
+type healthConfig struct {
+	Probes           []probeConfig `json:"probes"`
+	FailureThreshold int           `json:"failure_threshold,omitempty"`
+}
+
+// healthMu guards all of the package-level probe state below. That state
+// is reached concurrently - via evaluateHealth() from the ticker-driven
+// performCheck() loop and via probeOnce() from recoverApp()'s post-restart
+// verification, which can itself be running on the crash-recovery
+// goroutine (see job.go) - so
+// reading/updating healthFailures and lazily loading the probe list both
+// need to happen under a lock rather than as bare package vars.
+var (
+	healthMu           sync.Mutex
+	healthProbes       []HealthProbe
+	healthThreshold    = defaultFailureThreshold
+	healthFailures     int
+	healthConfigLoaded bool
+)
+
+// ensureProbesLoadedLocked lazily loads the probe config on first use.
+// Must be called with healthMu held.
+func ensureProbesLoadedLocked() {
+	if healthConfigLoaded {
+		return
+	}
+	healthConfigLoaded = true
+
+	cfg := loadHealthConfig()
+	healthProbes = buildProbes(cfg)
+	healthThreshold = defaultFailureThreshold
+	if cfg != nil && cfg.FailureThreshold > 0 {
+		healthThreshold = cfg.FailureThreshold
+	}
+}
+
+// runProbes runs probes in order and reports whether any of them detected
+// the app.
+func runProbes(probes []HealthProbe) bool {
+	for _, probe := range probes {
+		ok, err := probe.Check()
+		if err != nil {
+			log(fmt.Sprintf("Health probe %s errored: %v", probe.Name(), err))
+			continue
+		}
+		if ok {
+			log(fmt.Sprintf("App detected via probe: %s", probe.Name()))
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateHealth runs the configured probes and reports whether the app
+// looks healthy, updating the rolling failure count under healthMu so it
+// can be called safely from multiple goroutines. This backs the ticker-
+// driven performCheck() loop's recovery decision, so failure_threshold is
+// measured against consecutive calls to evaluateHealth() specifically -
+// recoverApp()'s one-shot post-restart check uses probeOnce() instead so
+// it can't perturb that count.
+func evaluateHealth() bool {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	ensureProbesLoadedLocked()
+
+	if runProbes(healthProbes) {
+		healthFailures = 0
+		return true
+	}
+
+	healthFailures++
+	if healthFailures < healthThreshold {
+		log(fmt.Sprintf("No probe detected the app (failure %d/%d), deferring recovery", healthFailures, healthThreshold))
+		return true
+	}
+
+	log("App not detected by any configured probe")
+	healthFailures = 0
+	return false
+}
+
+// probeOnce runs the configured probes once and reports whether any
+// detected the app, without touching healthFailures. recoverApp() uses
+// this for its post-restart verification, which is a one-shot check, not
+// part of the monitoring loop's consecutive-failure window.
+func probeOnce() bool {
+	healthMu.Lock()
+	ensureProbesLoadedLocked()
+	probes := healthProbes
+	healthMu.Unlock()
+
+	if runProbes(probes) {
+		return true
+	}
+	log("App not detected by any configured probe")
+	return false
+}
+
+func loadHealthConfig() *healthConfig {
+	path := filepath.Join(appDataDir, healthConfigFileName)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var cfg healthConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		log(fmt.Sprintf("Error parsing %s: %v, using default health probe", healthConfigFileName, err))
+		return nil
+	}
+	return &cfg
+}
+
+func buildProbes(cfg *healthConfig) []HealthProbe {
+	if cfg == nil || len(cfg.Probes) == 0 {
+		return []HealthProbe{&pipeProbe{}, &mutexProcessProbe{}}
+	}
+
+	var probes []HealthProbe
+	for _, pc := range cfg.Probes {
+		timeout := defaultProbeTimeout
+		if pc.TimeoutMS > 0 {
+			timeout = time.Duration(pc.TimeoutMS) * time.Millisecond
+		}
+
+		switch pc.Type {
+		case "mutex":
+			probes = append(probes, &mutexProcessProbe{})
+		case "pipe":
+			probes = append(probes, &pipeProbe{})
+		case "tcp":
+			probes = append(probes, &tcpProbe{port: pc.Port, timeout: timeout})
+		case "http":
+			path := pc.Path
+			if path == "" {
+				path = defaultHealthzPath
+			}
+			probes = append(probes, &httpProbe{port: pc.Port, path: path, timeout: timeout})
+		case "window":
+			probes = append(probes, &windowProbe{title: pc.WindowTitle, timeout: timeout})
+		default:
+			log(fmt.Sprintf("Unknown probe type %q in %s, ignoring", pc.Type, healthConfigFileName))
+		}
+	}
+
+	if len(probes) == 0 {
+		return []HealthProbe{&pipeProbe{}, &mutexProcessProbe{}}
+	}
+	return probes
+}
+
+// mutexProcessProbe is the original check: is the app mutex held, or is
+// its process name present in the process list.
+type mutexProcessProbe struct{}
+
+func (p *mutexProcessProbe) Name() string { return "mutex" }
+
+func (p *mutexProcessProbe) Check() (bool, error) {
+	if checkMutex(appMutexName) {
+		return true, nil
+	}
+
+	processes, err := getProcessList()
+	if err != nil {
+		return false, err
+	}
+	for _, proc := range processes {
+		if strings.EqualFold(proc, appExeName) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// pipeProbe asks the app's named pipe for a health status (see ipc.go).
+type pipeProbe struct{}
+
+func (p *pipeProbe) Name() string { return "pipe" }
+
+func (p *pipeProbe) Check() (bool, error) {
+	resp, err := pingApp()
+	if err != nil {
+		return false, err
+	}
+	return resp.Status != "", nil
+}
+
+// tcpProbe dials a loopback port the app is expected to expose.
+type tcpProbe struct {
+	port    int
+	timeout time.Duration
+}
+
+func (p *tcpProbe) Name() string { return fmt.Sprintf("tcp:%d", p.port) }
+
+func (p *tcpProbe) Check() (bool, error) {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", p.port), p.timeout)
+	if err != nil {
+		return false, err
+	}
+	conn.Close()
+	return true, nil
+}
+
+// httpProbe GETs a /healthz-style endpoint and expects {"ok": true}.
+type httpProbe struct {
+	port    int
+	path    string
+	timeout time.Duration
+}
+
+func (p *httpProbe) Name() string { return fmt.Sprintf("http:%d%s", p.port, p.path) }
+
+func (p *httpProbe) Check() (bool, error) {
+	client := http.Client{Timeout: p.timeout}
+
+	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d%s", p.port, p.path))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		OK bool `json:"ok"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return false, fmt.Errorf("decoding healthz body: %w", err)
+	}
+	return body.OK, nil
+}
+
+// windowProbe detects UI-thread hangs by sending WM_NULL with
+// SendMessageTimeout and waiting for the message loop to pump it. With window_title configured it targets
+// that window specifically; otherwise it looks up the supervised app's
+// own top-level window by the PID recoverApp() last started, so a hung
+// window elsewhere on the system can't make this probe report healthy.
+type windowProbe struct {
+	title   string
+	timeout time.Duration
+}
+
+var (
+	user32                    = syscall.NewLazyDLL("user32.dll")
+	procFindWindow            = user32.NewProc("FindWindowW")
+	procSendMessageTimeout    = user32.NewProc("SendMessageTimeoutW")
+	procEnumWindows           = user32.NewProc("EnumWindows")
+	procGetWindowThreadProcID = user32.NewProc("GetWindowThreadProcessId")
+	procIsWindowVisible       = user32.NewProc("IsWindowVisible")
+)
+
+const (
+	wmNull          = 0x0000
+	smtoBlock       = 0x0001
+	smtoAbortIfHung = 0x0002
+)
+
+func (p *windowProbe) Name() string {
+	if p.title == "" {
+		return "window:app"
+	}
+	return "window:" + p.title
+}
+
+func (p *windowProbe) Check() (bool, error) {
+	var target uintptr
+
+	if p.title != "" {
+		titlePtr, err := syscall.UTF16PtrFromString(p.title)
+		if err != nil {
+			return false, err
+		}
+		hwnd, _, _ := procFindWindow.Call(0, uintptr(unsafe.Pointer(titlePtr)))
+		if hwnd == 0 {
+			return false, fmt.Errorf("window %q not found", p.title)
+		}
+		target = hwnd
+	} else {
+		pid := atomic.LoadInt32(&currentAppPID)
+		if pid == 0 {
+			return false, fmt.Errorf("app has not been started by this helper yet, no PID to probe")
+		}
+		hwnd, err := findTopLevelWindowForPID(uint32(pid))
+		if err != nil {
+			return false, err
+		}
+		target = hwnd
+	}
+
+	var result uintptr
+	ret, _, err := procSendMessageTimeout.Call(
+		target,
+		wmNull,
+		0,
+		0,
+		uintptr(smtoAbortIfHung|smtoBlock),
+		uintptr(p.timeout.Milliseconds()),
+		uintptr(unsafe.Pointer(&result)),
+	)
+	if ret == 0 {
+		return false, fmt.Errorf("SendMessageTimeout: window not responding, possible UI-thread hang: %w", err)
+	}
+	return true, nil
+}
+
+// findTopLevelWindowForPID enumerates top-level windows looking for a
+// visible one owned by pid, so the window probe targets the supervised
+// app specifically rather than any window on the system.
+func findTopLevelWindowForPID(pid uint32) (uintptr, error) {
+	var found uintptr
+
+	cb := syscall.NewCallback(func(hwnd uintptr, lparam uintptr) uintptr {
+		var windowPID uint32
+		procGetWindowThreadProcID.Call(hwnd, uintptr(unsafe.Pointer(&windowPID)))
+		if windowPID != pid {
+			return 1 // continue enumerating
+		}
+
+		visible, _, _ := procIsWindowVisible.Call(hwnd)
+		if visible == 0 {
+			return 1
+		}
+
+		found = hwnd
+		return 0 // stop enumerating
+	})
+
+	procEnumWindows.Call(cb, 0)
+
+	if found == 0 {
+		return 0, fmt.Errorf("no visible top-level window found for pid %d", pid)
+	}
+	return found, nil
+}
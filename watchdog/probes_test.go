@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestBuildProbesDefaultsWhenNoConfig(t *testing.T) {
+	probes := buildProbes(nil)
+
+	if len(probes) != 2 {
+		t.Fatalf("got %d probes, want 2 (pipe, mutex)", len(probes))
+	}
+	if _, ok := probes[0].(*pipeProbe); !ok {
+		t.Errorf("probes[0] = %T, want *pipeProbe", probes[0])
+	}
+	if _, ok := probes[1].(*mutexProcessProbe); !ok {
+		t.Errorf("probes[1] = %T, want *mutexProcessProbe", probes[1])
+	}
+}
+
+func TestBuildProbesDefaultsWhenConfigEmpty(t *testing.T) {
+	probes := buildProbes(&healthConfig{})
+
+	if len(probes) != 2 {
+		t.Fatalf("got %d probes, want 2 (pipe, mutex)", len(probes))
+	}
+}
+
+func TestBuildProbesFromConfig(t *testing.T) {
+	cfg := &healthConfig{
+		Probes: []probeConfig{
+			{Type: "tcp", Port: 9000},
+			{Type: "http", Port: 9001},
+			{Type: "window", WindowTitle: "MyApp"},
+			{Type: "bogus"},
+		},
+	}
+
+	probes := buildProbes(cfg)
+
+	if len(probes) != 3 {
+		t.Fatalf("got %d probes, want 3 (unknown type dropped)", len(probes))
+	}
+
+	tcp, ok := probes[0].(*tcpProbe)
+	if !ok || tcp.port != 9000 {
+		t.Errorf("probes[0] = %#v, want tcpProbe{port: 9000}", probes[0])
+	}
+
+	httpP, ok := probes[1].(*httpProbe)
+	if !ok || httpP.port != 9001 || httpP.path != defaultHealthzPath {
+		t.Errorf("probes[1] = %#v, want httpProbe{port: 9001, path: %q}", probes[1], defaultHealthzPath)
+	}
+
+	win, ok := probes[2].(*windowProbe)
+	if !ok || win.title != "MyApp" {
+		t.Errorf("probes[2] = %#v, want windowProbe{title: \"MyApp\"}", probes[2])
+	}
+}
+
+func TestBuildProbesCustomTimeout(t *testing.T) {
+	cfg := &healthConfig{
+		Probes: []probeConfig{
+			{Type: "tcp", Port: 9000, TimeoutMS: 500},
+		},
+	}
+
+	probes := buildProbes(cfg)
+	tcp, ok := probes[0].(*tcpProbe)
+	if !ok {
+		t.Fatalf("probes[0] = %T, want *tcpProbe", probes[0])
+	}
+	if tcp.timeout.Milliseconds() != 500 {
+		t.Errorf("timeout = %v, want 500ms", tcp.timeout)
+	}
+}
+
+func TestLoadHealthConfigMissingFile(t *testing.T) {
+	old := appDataDir
+	appDataDir = t.TempDir()
+	defer func() { appDataDir = old }()
+
+	if cfg := loadHealthConfig(); cfg != nil {
+		t.Errorf("loadHealthConfig() = %#v, want nil for missing file", cfg)
+	}
+}
@@ -0,0 +1,237 @@
+// Windows Service Control Manager integration for the service helper.
+//
+// This lets the helper be installed as a real Windows service
+// (`a1_service_helper.exe --install`) instead of only running as an ad-hoc
+// background process under the logged-in user: SCM starts it at boot with
+// a delayed auto-start and restarts it on crash via its recovery actions.
+// --install also registers an Event Log source for the service, but the
+// helper's log records go only to its log file (and ETW with --etw); none
+// are written to the Event Log. The --install/--uninstall/
+// --start/--stop subcommands are the admin-facing surface; a1ServiceHandler
+// is the runtime surface SCM talks to once the service is running.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	serviceName        = "A1ToolsServiceHelper"
+	serviceDisplayName = "A1 Tools Service Helper"
+	serviceDescription = "Monitors A1 Tools and restarts it if it stops responding or exits unexpectedly."
+)
+
+// a1ServiceHandler implements svc.Handler, translating SCM control
+// requests into starts/stops of the existing performCheck() loop.
+type a1ServiceHandler struct{}
+
+func (h *a1ServiceHandler) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue
+
+	changes <- svc.Status{State: svc.StartPending}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		runCheckLoop(stop)
+		close(done)
+	}()
+
+	state := svc.Running
+	changes <- svc.Status{State: svc.Running, Accepts: accepted}
+	log("Service running under SCM")
+
+loop:
+	for c := range r {
+		switch c.Cmd {
+		case svc.Interrogate:
+			changes <- c.CurrentStatus
+
+		case svc.Stop, svc.Shutdown:
+			log("SCM requested stop/shutdown")
+			changes <- svc.Status{State: svc.StopPending}
+			close(stop)
+			<-done
+			break loop
+
+		case svc.Pause:
+			if state == svc.Paused {
+				log("SCM requested pause while already paused, ignoring")
+				changes <- svc.Status{State: svc.Paused, Accepts: accepted}
+				continue
+			}
+			log("SCM requested pause")
+			close(stop)
+			<-done
+			state = svc.Paused
+			changes <- svc.Status{State: svc.Paused, Accepts: accepted}
+
+		case svc.Continue:
+			if state == svc.Running {
+				log("SCM requested continue while already running, ignoring")
+				changes <- svc.Status{State: svc.Running, Accepts: accepted}
+				continue
+			}
+			log("SCM requested continue")
+			stop = make(chan struct{})
+			done = make(chan struct{})
+			go func() {
+				runCheckLoop(stop)
+				close(done)
+			}()
+			state = svc.Running
+			changes <- svc.Status{State: svc.Running, Accepts: accepted}
+
+		default:
+			log(fmt.Sprintf("Unexpected SCM control request: %d", c.Cmd))
+		}
+	}
+
+	changes <- svc.Status{State: svc.Stopped}
+	return false, 0
+}
+
+// handleServiceControlCommand implements the --install/--uninstall/
+// --start/--stop subcommands. It is called before logging is initialized
+// so failures are reported to stderr rather than the log file.
+func handleServiceControlCommand(install, uninstall, start, stop bool) error {
+	switch {
+	case install:
+		return installService()
+	case uninstall:
+		return uninstallService()
+	case start:
+		return startService()
+	case stop:
+		return stopService()
+	}
+	return nil
+}
+
+func installService() error {
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("resolving executable path: %w", err)
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if existing, err := m.OpenService(serviceName); err == nil {
+		existing.Close()
+		return fmt.Errorf("service %s is already installed", serviceName)
+	}
+
+	s, err := m.CreateService(serviceName, exePath, mgr.Config{
+		DisplayName:      serviceDisplayName,
+		Description:      serviceDescription,
+		StartType:        mgr.StartAutomatic,
+		DelayedAutoStart: true,
+		ErrorControl:     mgr.ErrorNormal,
+	})
+	if err != nil {
+		return fmt.Errorf("creating service: %w", err)
+	}
+	defer s.Close()
+
+	// Restart on failure with exponential backoff; reset the failure
+	// count after a day of healthy running.
+	recoveryActions := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 10 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 2 * time.Minute},
+	}
+	if err := s.SetRecoveryActions(recoveryActions, uint32((24 * time.Hour).Seconds())); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to set recovery actions: %v\n", err)
+	}
+
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to register event log source: %v\n", err)
+	}
+
+	return nil
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", serviceName, err)
+	}
+	defer s.Close()
+
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("deleting service: %w", err)
+	}
+
+	if err := eventlog.Remove(serviceName); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to remove event log source: %v\n", err)
+	}
+
+	return nil
+}
+
+func startService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", serviceName, err)
+	}
+	defer s.Close()
+
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("starting service: %w", err)
+	}
+	return nil
+}
+
+func stopService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", serviceName, err)
+	}
+	defer s.Close()
+
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return fmt.Errorf("sending stop control: %w", err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for service to stop")
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return fmt.Errorf("querying service status: %w", err)
+		}
+	}
+	return nil
+}